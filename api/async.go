@@ -0,0 +1,397 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo"
+	"github.com/sdwolfe32/trumail/verifier"
+)
+
+// JobStatus describes where an async Lookup job is in its lifecycle
+type JobStatus string
+
+const (
+	JobStatusQueued  JobStatus = "queued"
+	JobStatusRunning JobStatus = "running"
+	JobStatusDone    JobStatus = "done"
+	JobStatusError   JobStatus = "error"
+)
+
+// webhookMaxAttempts bounds how many times a callback_url delivery is
+// attempted, the first try included, before the job gives up on notifying
+// the caller
+const webhookMaxAttempts = 5
+
+// completedJobTTL is how long a finished job is kept in memoryJobStore
+// before it's swept, so a long-running deployment doesn't grow its job
+// map without bound
+const completedJobTTL = 24 * time.Hour
+
+var (
+	// ErrJobNotFound is thrown when an async job id doesn't exist
+	ErrJobNotFound = echo.NewHTTPError(http.StatusNotFound, "No job found with the provided id")
+	// ErrInvalidEmail is thrown when an async lookup request omits an email
+	ErrInvalidEmail = echo.NewHTTPError(http.StatusBadRequest, "A valid email address is required")
+	// ErrInvalidCallbackURL is thrown when callback_url fails SSRF validation
+	ErrInvalidCallbackURL = echo.NewHTTPError(http.StatusBadRequest, "callback_url must be an https URL that does not resolve to a loopback, private, link-local, or metadata address")
+)
+
+// Job tracks a single async verification request, its result once
+// complete, and the callback to notify on completion. Job is handed
+// around by value so callers never hold a pointer a worker is
+// concurrently mutating; JobStore is the only place a Job is written.
+type Job struct {
+	ID          string           `json:"id"`
+	Status      JobStatus        `json:"status"`
+	Email       string           `json:"email"`
+	CallbackURL string           `json:"callback_url,omitempty"`
+	Lookup      *verifier.Lookup `json:"lookup,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+// done reports whether the job has reached a terminal state
+func (j Job) done() bool {
+	return j.Status == JobStatusDone || j.Status == JobStatusError
+}
+
+// JobStore persists async Jobs by value, so every read returns a snapshot
+// that's safe to serialize or inspect without racing a worker's updates.
+// The default is an in-memory store; operators running multiple replicas
+// can supply their own.
+type JobStore interface {
+	Create(job Job) error
+	Get(id string) (Job, bool)
+	// Update applies mutate to the stored job under the store's lock and
+	// returns the updated value, so read-modify-write is atomic from the
+	// caller's perspective
+	Update(id string, mutate func(*Job)) (Job, error)
+}
+
+// memoryJobStore is the default in-process JobStore
+type memoryJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+// NewMemoryJobStore returns the default in-memory JobStore, which sweeps
+// completed jobs older than completedJobTTL in the background
+func NewMemoryJobStore() JobStore {
+	m := &memoryJobStore{jobs: make(map[string]Job)}
+	go m.sweep()
+	return m
+}
+
+func (m *memoryJobStore) Create(job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[job.ID] = job
+	return nil
+}
+
+func (m *memoryJobStore) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+func (m *memoryJobStore) Update(id string, mutate func(*Job)) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	mutate(&job)
+	m.jobs[id] = job
+	return job, nil
+}
+
+// sweep periodically evicts completed/errored jobs past completedJobTTL
+// so memoryJobStore's map doesn't grow unbounded on a long-running process
+func (m *memoryJobStore) sweep() {
+	for range time.Tick(completedJobTTL / 24) {
+		m.mu.Lock()
+		for id, job := range m.jobs {
+			if job.done() && time.Since(job.UpdatedAt) > completedJobTTL {
+				delete(m.jobs, id)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// AsyncLookup queues an email verification and returns 202 Accepted with
+// a job id immediately, for callers whose SMTP conversation (catch-all
+// probes, greylisted domains) would otherwise exceed their request
+// timeout. Poll GET /v1/verify/async/:id, or supply a callback_url to
+// receive a signed webhook once the job completes.
+func (s *Service) AsyncLookup(c echo.Context) error {
+	l := s.log.WithField("handler", "AsyncLookup")
+	l.Debug("New AsyncLookup request received")
+
+	var req struct {
+		Email       string `json:"email"`
+		CallbackURL string `json:"callback_url"`
+	}
+	if err := c.Bind(&req); err != nil {
+		l.WithError(err).Error("Failed to bind AsyncLookup request")
+		return s.countAndRespond(c, http.StatusBadRequest, err)
+	}
+	if _, err := verifier.ParseAddress(req.Email); err != nil {
+		l.WithError(err).Error("Failed to parse email address")
+		return s.countAndRespond(c, http.StatusBadRequest, ErrInvalidEmail)
+	}
+	if req.CallbackURL != "" {
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			l.WithError(err).Error("Rejected callback_url")
+			return s.countAndRespond(c, http.StatusBadRequest, ErrInvalidCallbackURL)
+		}
+	}
+
+	now := time.Now()
+	job := Job{
+		ID:          uuid.New().String(),
+		Status:      JobStatusQueued,
+		Email:       req.Email,
+		CallbackURL: req.CallbackURL,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.jobStore.Create(job); err != nil {
+		l.WithError(err).Error("Failed to persist job")
+		return s.countAndRespond(c, http.StatusInternalServerError, err)
+	}
+	s.jobQueue <- job.ID
+
+	l.WithField("job", job.ID).Debug("Queued async Lookup job")
+	return respond(c, http.StatusAccepted, job)
+}
+
+// AsyncLookupStatus returns the current state of a previously queued job,
+// including its Lookup once the verification has completed
+func (s *Service) AsyncLookupStatus(c echo.Context) error {
+	l := s.log.WithField("handler", "AsyncLookupStatus")
+	id := c.Param("id")
+	job, ok := s.jobStore.Get(id)
+	if !ok {
+		l.WithField("job", id).Debug("No job found with the provided id")
+		return s.countAndRespond(c, http.StatusNotFound, ErrJobNotFound)
+	}
+	return respond(c, http.StatusOK, job)
+}
+
+// runAsyncWorkers drains s.jobQueue with n concurrent workers, performing
+// the verification and delivering the webhook (if any) for each job. It's
+// started once at service construction and runs for the life of the process.
+func (s *Service) runAsyncWorkers(n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for id := range s.jobQueue {
+				s.processAsyncJob(id)
+			}
+		}()
+	}
+}
+
+// processAsyncJob performs the verification for a single job and updates
+// the JobStore with the outcome. Every mutation goes through JobStore.Update,
+// which applies it under the store's lock, so a concurrent status poll
+// never observes a job pointer mid-mutation.
+func (s *Service) processAsyncJob(id string) {
+	l := s.log.WithField("job", id)
+
+	job, err := s.jobStore.Update(id, func(j *Job) {
+		j.Status = JobStatusRunning
+		j.UpdatedAt = time.Now()
+	})
+	if err != nil {
+		l.WithError(err).Error("Failed to mark job running")
+		return
+	}
+
+	address, err := verifier.ParseAddress(job.Email)
+	var lookup *verifier.Lookup
+	if err == nil {
+		// Async jobs have no request in flight to read an exempt API key
+		// from, so this enforces the domain quota directly rather than
+		// through allowDomainProbe - the worker pool performs the exact
+		// same SMTP probes BulkLookup and Lookup do and must be bound by
+		// the same per-target-domain quota
+		if s.domainLimiters.allow(address.Domain) {
+			lookup, err = s.verifier.VerifyAddressTimeout(address, s.timeout)
+		} else {
+			err = ErrRateLimited
+		}
+	}
+
+	job, updateErr := s.jobStore.Update(id, func(j *Job) {
+		j.UpdatedAt = time.Now()
+		if err != nil {
+			j.Status = JobStatusError
+			j.Error = err.Error()
+			return
+		}
+		j.Status = JobStatusDone
+		j.Lookup = lookup
+	})
+	if err != nil {
+		l.WithError(err).Error("Async verification failed")
+	}
+	if updateErr != nil {
+		l.WithError(updateErr).Error("Failed to persist job outcome")
+		return
+	}
+
+	if job.CallbackURL != "" {
+		s.deliverWebhook(job)
+	}
+}
+
+// deliverWebhook POSTs the finished job to its callback_url, retrying
+// with exponential backoff and signing the body with HMAC-SHA256 so the
+// recipient can verify the delivery came from this service
+func (s *Service) deliverWebhook(job Job) {
+	l := s.log.WithField("job", job.ID).WithField("callback_url", job.CallbackURL)
+
+	body, err := json.Marshal(job)
+	if err != nil {
+		l.WithError(err).Error("Failed to marshal webhook payload")
+		return
+	}
+	signature := signWebhookBody(s.webhookSecret, body)
+
+	backoff := time.Second
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, job.CallbackURL, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Trumail-Signature", signature)
+			resp, err := webhookHTTPClient.Do(req)
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode < 300 {
+					l.WithField("attempt", attempt).Debug("Delivered webhook")
+					return
+				}
+				err = fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+			}
+			l.WithError(err).WithField("attempt", attempt).Warn("Webhook delivery failed")
+		}
+		if attempt < webhookMaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	l.Error("Exhausted webhook delivery attempts")
+}
+
+// signWebhookBody returns the hex-encoded HMAC-SHA256 of body using secret,
+// allowing recipients to verify a webhook really came from this service
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateCallbackURL rejects callback_url values that could be used to
+// make this service issue signed requests against internal infrastructure
+// (SSRF): it must be https, and neither the hostname nor any address it
+// resolves to may be loopback, private, link-local, or the cloud metadata
+// address. This runs at job-acceptance time, not just before delivery, so
+// a rejected callback never gets queued in the first place. It doesn't pin
+// the resolved address - the hostname could rebind to a disallowed address
+// by the time deliverWebhook dials it, which is why deliverWebhook does
+// its own resolution and pinning rather than trusting this check alone.
+func validateCallbackURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("api: callback_url must use https")
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("api: callback_url has no host")
+	}
+
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && isDisallowedCallbackIP(ip) {
+			return fmt.Errorf("api: callback_url resolves to disallowed address %s", addr)
+		}
+	}
+	return nil
+}
+
+// pinnedDialContext returns a DialContext that resolves host once, rejects
+// it if it's disallowed, and dials the resolved address directly rather
+// than letting net/http re-resolve the hostname itself. This is what
+// prevents a callback_url from passing validateCallbackURL at job-acceptance
+// time against a public address and then rebinding DNS to the cloud
+// metadata address or an internal host before (or between) delivery
+// attempts - the address actually dialed is the one pinned here, not
+// whatever the hostname resolves to at request time.
+func pinnedDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if isDisallowedCallbackIP(ip.IP) {
+			return nil, fmt.Errorf("api: callback_url resolved to disallowed address %s", ip.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("api: callback_url host %s did not resolve", host)
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+}
+
+// webhookHTTPClient is used for every callback_url delivery attempt; its
+// DialContext pins and revalidates the destination address on every dial
+// (including each retry) instead of trusting the hostname to still resolve
+// to the address validateCallbackURL approved, while still sending the
+// original hostname as the Host header and TLS SNI via http.Transport's
+// normal handling of the request URL
+var webhookHTTPClient = &http.Client{
+	Transport: &http.Transport{DialContext: pinnedDialContext},
+}
+
+// metadataServiceIP is the well-known cloud instance-metadata address
+// (AWS/GCP/Azure all use it) that must never be reachable via a webhook
+const metadataServiceIP = "169.254.169.254"
+
+// isDisallowedCallbackIP reports whether ip is loopback, private,
+// link-local, unspecified, or the cloud metadata address
+func isDisallowedCallbackIP(ip net.IP) bool {
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() ||
+		ip.String() == metadataServiceIP
+}
@@ -0,0 +1,70 @@
+package api
+
+import "testing"
+
+func TestValidateCallbackURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{"rejects non-https", "http://example.com/hook", true},
+		{"rejects loopback", "https://127.0.0.1/hook", true},
+		{"rejects localhost", "https://localhost/hook", true},
+		{"rejects cloud metadata ip", "https://169.254.169.254/latest/meta-data/", true},
+		{"rejects private range", "https://10.0.0.5/hook", true},
+		{"rejects link-local", "https://169.254.1.1/hook", true},
+		{"rejects malformed url", "://not-a-url", true},
+		{"accepts public https host", "https://93.184.216.34/hook", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCallbackURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateCallbackURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignWebhookBody(t *testing.T) {
+	body := []byte(`{"id":"abc"}`)
+	sig1 := signWebhookBody("secret-a", body)
+	sig2 := signWebhookBody("secret-a", body)
+	if sig1 != sig2 {
+		t.Errorf("signWebhookBody is not deterministic: %q != %q", sig1, sig2)
+	}
+	if sig3 := signWebhookBody("secret-b", body); sig3 == sig1 {
+		t.Errorf("signWebhookBody produced the same signature for different secrets")
+	}
+}
+
+func TestMemoryJobStoreUpdateIsAtomic(t *testing.T) {
+	store := &memoryJobStore{jobs: make(map[string]Job)}
+	job := Job{ID: "job-1", Status: JobStatusQueued}
+	if err := store.Create(job); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	updated, err := store.Update("job-1", func(j *Job) {
+		j.Status = JobStatusDone
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if updated.Status != JobStatusDone {
+		t.Errorf("Update() status = %q, want %q", updated.Status, JobStatusDone)
+	}
+
+	got, ok := store.Get("job-1")
+	if !ok {
+		t.Fatal("Get() did not find job-1")
+	}
+	if got.Status != JobStatusDone {
+		t.Errorf("Get() status = %q, want %q", got.Status, JobStatusDone)
+	}
+
+	if _, err := store.Update("missing", func(j *Job) {}); err != ErrJobNotFound {
+		t.Errorf("Update() on missing job error = %v, want %v", err, ErrJobNotFound)
+	}
+}
@@ -0,0 +1,306 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo"
+	"github.com/sdwolfe32/trumail/verifier"
+)
+
+// defaultBulkWorkers is the number of concurrent verifications BulkLookup
+// runs when the caller doesn't override it via the "workers" query param
+const defaultBulkWorkers = 10
+
+// maxBulkWorkers caps the "workers" query param so a single request can't
+// spin up an unbounded number of concurrent SMTP probes
+const maxBulkWorkers = 100
+
+// maxBulkBodyBytes caps how much of the request body BulkLookup will read,
+// so a single request can't exhaust memory with a multi-GB upload
+const maxBulkBodyBytes = 256 << 20 // 256MiB
+
+// bulkRecord pairs a requested email with the Lookup (or error) produced
+// for it so a result can be streamed out as soon as it's ready
+type bulkRecord struct {
+	Email  string           `json:"email"`
+	Lookup *verifier.Lookup `json:"lookup,omitempty"`
+	Error  string           `json:"error,omitempty"`
+
+	// rateLimited marks a record that failed because it hit the
+	// per-target-domain probe quota, so record() can tally it the same
+	// way the single-lookup path does instead of as a generic error
+	rateLimited bool
+}
+
+// BulkLookup performs concurrent email verifications against a list of
+// addresses supplied as a JSON array, newline-delimited JSON/text, or CSV
+// upload, streaming each result back to the caller as NDJSON or CSV as
+// soon as it completes rather than buffering the full batch in memory.
+// A bad address only fails that record, never the rest of the batch.
+func (s *Service) BulkLookup(c echo.Context) error {
+	l := s.log.WithField("handler", "BulkLookup")
+	l.Debug("New BulkLookup request received")
+
+	emails, err := parseBulkEmails(c)
+	if err != nil {
+		l.WithError(err).Error("Failed to parse bulk email list")
+		return s.countAndRespond(c, http.StatusBadRequest, err)
+	}
+	l = l.WithField("count", len(emails))
+	l.Debug("Parsed bulk email list")
+
+	workers := resolveBulkWorkers(c.QueryParam("workers"))
+
+	// ctx is cancelled as soon as the client disconnects; every channel
+	// send below selects on it so an aborted request can't leave workers
+	// or the producer blocked forever on an unbuffered channel nobody is
+	// reading from anymore
+	ctx := c.Request().Context()
+
+	jobs := make(chan string)
+	results := make(chan bulkRecord)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for email := range jobs {
+				select {
+				case results <- s.verifyBulkAddress(c, email):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, email := range emails {
+			select {
+			case jobs <- email:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Stream each record to the client as it finishes, flushing after
+	// every write so large lists can be processed incrementally
+	flusher, _ := c.Response().Writer.(http.Flusher)
+	code := http.StatusOK
+	for {
+		select {
+		case res, ok := <-results:
+			if !ok {
+				return nil
+			}
+			s.count(c, res.record())
+			if err := respond(c, code, res); err != nil {
+				l.WithError(err).Error("Failed to write bulk record")
+				return nil
+			}
+			code = 0 // only the first write sets the status
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// resolveBulkWorkers parses the "workers" query param, falling back to
+// defaultBulkWorkers when absent or invalid and clamping to maxBulkWorkers
+// so a single request can't spin up unbounded concurrent SMTP probes
+func resolveBulkWorkers(raw string) int {
+	workers := defaultBulkWorkers
+	if w, err := strconv.Atoi(raw); err == nil && w > 0 {
+		workers = w
+	}
+	if workers > maxBulkWorkers {
+		workers = maxBulkWorkers
+	}
+	return workers
+}
+
+// record returns the value that should be passed to count() for this
+// bulkRecord, preserving the same deliverable/undeliverable/error buckets
+// the single-lookup path reports
+func (r bulkRecord) record() interface{} {
+	if r.Lookup != nil {
+		return r.Lookup
+	}
+	if r.rateLimited {
+		return rateLimitedEvent{}
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, r.Error)
+}
+
+// verifyBulkAddress parses and verifies a single address within a bulk
+// request, consulting the shared lookupCache first so duplicate addresses
+// within or across requests don't re-hit the remote MX. A cache miss still
+// has to clear the target domain's probe quota before it's allowed to
+// perform the real SMTP conversation - BulkLookup runs up to maxBulkWorkers
+// probes concurrently, so without this a single batch could hammer a
+// domain's MX far harder than Lookup's per-request quota ever would.
+func (s *Service) verifyBulkAddress(c echo.Context, raw string) bulkRecord {
+	address, err := verifier.ParseAddress(raw)
+	if err != nil {
+		return bulkRecord{Email: raw, Error: err.Error()}
+	}
+	if lookup, ok := s.lookupCache.Get(address.MD5Hash); ok {
+		return bulkRecord{Email: raw, Lookup: lookup}
+	}
+	if !s.allowDomainProbe(c, address.Domain) {
+		return bulkRecord{Email: raw, Error: ErrRateLimited.Message.(string), rateLimited: true}
+	}
+	lookup, err := s.verifier.VerifyAddressTimeout(address, s.timeout)
+	if err != nil {
+		return bulkRecord{Email: raw, Error: err.Error()}
+	}
+	if shouldCacheLookup(lookup) {
+		s.lookupCache.Set(address.MD5Hash, lookup, cacheTTL(s, lookup))
+	}
+	return bulkRecord{Email: raw, Lookup: lookup}
+}
+
+// parseBulkEmails reads the address list from the request body, supporting
+// a JSON array, newline-delimited emails (one per line, JSON or plain
+// text), or a CSV file upload under the "file" form field
+func parseBulkEmails(c echo.Context) ([]string, error) {
+	if file, err := c.FormFile("file"); err == nil {
+		return parseBulkEmailsCSV(file)
+	}
+
+	limited := http.MaxBytesReader(c.Response().Writer, c.Request().Body, maxBulkBodyBytes)
+	body, err := io.ReadAll(limited)
+	if err != nil {
+		return nil, err
+	}
+	body = []byte(strings.TrimSpace(string(body)))
+	if len(body) == 0 {
+		return nil, ErrEmptyBulkRequest
+	}
+
+	if body[0] == '[' {
+		var emails []string
+		if err := json.Unmarshal(body, &emails); err != nil {
+			return nil, err
+		}
+		return emails, nil
+	}
+
+	var emails []string
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var email string
+		if err := json.Unmarshal([]byte(line), &email); err == nil {
+			emails = append(emails, email)
+			continue
+		}
+		emails = append(emails, line)
+	}
+	return emails, scanner.Err()
+}
+
+// parseBulkEmailsCSV reads a single column of email addresses from an
+// uploaded CSV file, skipping a header row if the first cell isn't an
+// address (doesn't contain an "@")
+func parseBulkEmailsCSV(fh *multipart.FileHeader) ([]string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var emails []string
+	r := csv.NewReader(f)
+	first := true
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) == 0 {
+			continue
+		}
+		if first {
+			first = false
+			if !strings.Contains(row[0], "@") {
+				continue
+			}
+		}
+		emails = append(emails, strings.TrimSpace(row[0]))
+	}
+	return emails, nil
+}
+
+// respondNDJSON writes res as a single newline-delimited JSON record,
+// used by BulkLookup to stream results as they complete
+func respondNDJSON(c echo.Context, code int, res interface{}) error {
+	if code != 0 {
+		c.Response().WriteHeader(code)
+	}
+	return json.NewEncoder(c.Response()).Encode(res)
+}
+
+// respondCSV writes res as a single CSV row, emitting a header row on the
+// first call, used by BulkLookup to stream results as they complete
+func respondCSV(c echo.Context, code int, res interface{}) error {
+	if code != 0 {
+		c.Response().Header().Set(echo.HeaderContentType, "text/csv")
+		c.Response().WriteHeader(code)
+	}
+	w := csv.NewWriter(c.Response())
+	if code != 0 {
+		if err := w.Write(bulkCSVHeader); err != nil {
+			return err
+		}
+	}
+	if err := w.Write(bulkCSVRow(res)); err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+var bulkCSVHeader = []string{"email", "deliverable", "error"}
+
+// bulkCSVRow flattens a bulkRecord (or a bare error) into the columns
+// declared in bulkCSVHeader
+func bulkCSVRow(res interface{}) []string {
+	switch r := res.(type) {
+	case bulkRecord:
+		if r.Lookup != nil {
+			return []string{r.Email, strconv.FormatBool(r.Lookup.Deliverable), ""}
+		}
+		return []string{r.Email, "", r.Error}
+	case error:
+		return []string{"", "", r.Error()}
+	default:
+		return []string{"", "", ""}
+	}
+}
+
+// ErrEmptyBulkRequest is thrown when a BulkLookup request body contains
+// no addresses to verify
+var ErrEmptyBulkRequest = echo.NewHTTPError(http.StatusBadRequest, "No email addresses provided")
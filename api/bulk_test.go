@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestResolveBulkWorkers(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want int
+	}{
+		{"empty falls back to default", "", defaultBulkWorkers},
+		{"invalid falls back to default", "not-a-number", defaultBulkWorkers},
+		{"zero falls back to default", "0", defaultBulkWorkers},
+		{"negative falls back to default", "-5", defaultBulkWorkers},
+		{"within bound is honored", "25", 25},
+		{"over bound is clamped", "100000", maxBulkWorkers},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveBulkWorkers(tt.raw); got != tt.want {
+				t.Errorf("resolveBulkWorkers(%q) = %d, want %d", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBulkEmailsJSONArray(t *testing.T) {
+	body := `["a@example.com", "b@example.com"]`
+	req := httptest.NewRequest(http.MethodPost, "/v1/bulk", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	emails, err := parseBulkEmails(c)
+	if err != nil {
+		t.Fatalf("parseBulkEmails() error = %v", err)
+	}
+	want := []string{"a@example.com", "b@example.com"}
+	if len(emails) != len(want) {
+		t.Fatalf("parseBulkEmails() = %v, want %v", emails, want)
+	}
+	for i, email := range want {
+		if emails[i] != email {
+			t.Errorf("parseBulkEmails()[%d] = %q, want %q", i, emails[i], email)
+		}
+	}
+}
+
+func TestParseBulkEmailsNewlineDelimited(t *testing.T) {
+	body := "a@example.com\nb@example.com\n"
+	req := httptest.NewRequest(http.MethodPost, "/v1/bulk", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	emails, err := parseBulkEmails(c)
+	if err != nil {
+		t.Fatalf("parseBulkEmails() error = %v", err)
+	}
+	if len(emails) != 2 || emails[0] != "a@example.com" || emails[1] != "b@example.com" {
+		t.Errorf("parseBulkEmails() = %v", emails)
+	}
+}
+
+func TestParseBulkEmailsEmptyBody(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/bulk", strings.NewReader(""))
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	if _, err := parseBulkEmails(c); err != ErrEmptyBulkRequest {
+		t.Errorf("parseBulkEmails() error = %v, want %v", err, ErrEmptyBulkRequest)
+	}
+}
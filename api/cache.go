@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	redis "github.com/go-redis/redis/v8"
+	cache "github.com/patrickmn/go-cache"
+	"github.com/sdwolfe32/trumail/verifier"
+)
+
+// LookupCache abstracts the store used to dedupe verifications across
+// requests (and, for the shared backends, across replicas). Implementations
+// are free to expire entries however they like; ttl is advisory and is
+// ignored by stores without a native per-key expiration.
+type LookupCache interface {
+	Get(key string) (*verifier.Lookup, bool)
+	Set(key string, l *verifier.Lookup, ttl time.Duration)
+}
+
+// NewLookupCache builds the LookupCache selected by backend ("memory",
+// "redis", or "memcached"), connecting to url when the backend needs one.
+// Service construction wires this from the CACHE_BACKEND/CACHE_URL env vars.
+func NewLookupCache(backend, url string) (LookupCache, error) {
+	switch backend {
+	case "", "memory":
+		return newMemoryCache(), nil
+	case "redis":
+		return newRedisCache(url), nil
+	case "memcached":
+		return newMemcachedCache(url), nil
+	default:
+		return nil, fmt.Errorf("api: unsupported CACHE_BACKEND %q", backend)
+	}
+}
+
+// memoryCache is the original in-process LookupCache, backed by
+// patrickmn/go-cache, for single-replica deployments
+type memoryCache struct{ c *cache.Cache }
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{c: cache.New(cache.NoExpiration, 10*time.Minute)}
+}
+
+func (m *memoryCache) Get(key string) (*verifier.Lookup, bool) {
+	v, ok := m.c.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*verifier.Lookup), true
+}
+
+func (m *memoryCache) Set(key string, l *verifier.Lookup, ttl time.Duration) {
+	m.c.Set(key, l, ttl)
+}
+
+// redisCache lets multiple Trumail replicas behind a load balancer share
+// verification results, so they don't each re-hit the same remote MX
+type redisCache struct{ c *redis.Client }
+
+func newRedisCache(url string) *redisCache {
+	opt, err := redis.ParseURL(url)
+	if err != nil {
+		// Fall back to treating url as a bare host:port, matching the
+		// simple CACHE_URL=host:6379 form ops tends to reach for first
+		opt = &redis.Options{Addr: url}
+	}
+	return &redisCache{c: redis.NewClient(opt)}
+}
+
+func (r *redisCache) Get(key string) (*verifier.Lookup, bool) {
+	b, err := r.c.Get(context.Background(), key).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	var l verifier.Lookup
+	if err := gobDecode(b, &l); err != nil {
+		return nil, false
+	}
+	return &l, true
+}
+
+func (r *redisCache) Set(key string, l *verifier.Lookup, ttl time.Duration) {
+	b, err := gobEncode(l)
+	if err != nil {
+		return
+	}
+	r.c.Set(context.Background(), key, b, ttl)
+}
+
+// memcachedCache is the memcached-backed LookupCache, for operators who
+// already run a memcached fleet alongside Trumail
+type memcachedCache struct{ c *memcache.Client }
+
+func newMemcachedCache(url string) *memcachedCache {
+	return &memcachedCache{c: memcache.New(url)}
+}
+
+func (m *memcachedCache) Get(key string) (*verifier.Lookup, bool) {
+	item, err := m.c.Get(memcachedKey(key))
+	if err != nil {
+		return nil, false
+	}
+	var l verifier.Lookup
+	if err := gobDecode(item.Value, &l); err != nil {
+		return nil, false
+	}
+	return &l, true
+}
+
+func (m *memcachedCache) Set(key string, l *verifier.Lookup, ttl time.Duration) {
+	b, err := gobEncode(l)
+	if err != nil {
+		return
+	}
+	m.c.Set(&memcache.Item{
+		Key:        memcachedKey(key),
+		Value:      b,
+		Expiration: int32(ttl.Seconds()),
+	})
+}
+
+// memcachedKey guards against the MD5 hash (or any other key) exceeding
+// memcached's 250-byte key limit; trumail's keys never will, but callers
+// of a shared cache backend shouldn't have to know that
+func memcachedKey(key string) string {
+	if len(key) > 250 {
+		return key[:250]
+	}
+	return key
+}
+
+func gobEncode(l *verifier.Lookup) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(l); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gobDecode(b []byte, l *verifier.Lookup) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(l)
+}
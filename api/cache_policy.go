@@ -0,0 +1,28 @@
+package api
+
+import (
+	"time"
+
+	"github.com/sdwolfe32/trumail/verifier"
+)
+
+// shouldCacheLookup reports whether a completed Lookup is trustworthy
+// enough to pin in s.lookupCache. Lookups for which VerifyAddressTimeout
+// returned a timeout or network error never reach here at all - Lookup
+// returns that error to the caller directly instead of caching anything.
+// The one case handled here is a catch-all domain: its "deliverable"
+// verdict reflects the domain accepting all addresses, not this specific
+// mailbox, so it isn't pinned for the full TTL either.
+func shouldCacheLookup(lookup *verifier.Lookup) bool {
+	return lookup != nil && !lookup.CatchAll
+}
+
+// cacheTTL returns how long a Lookup should live in s.lookupCache,
+// using the Service's configured positive/negative TTLs so undeliverable
+// verdicts can expire on a different schedule than deliverable ones
+func cacheTTL(s *Service, lookup *verifier.Lookup) time.Duration {
+	if lookup.Deliverable {
+		return s.positiveTTL
+	}
+	return s.negativeTTL
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/sdwolfe32/trumail/verifier"
+)
+
+func TestShouldCacheLookup(t *testing.T) {
+	tests := []struct {
+		name   string
+		lookup *verifier.Lookup
+		want   bool
+	}{
+		{"nil lookup", nil, false},
+		{"deliverable, not catch-all", &verifier.Lookup{Deliverable: true}, true},
+		{"undeliverable, not catch-all", &verifier.Lookup{Deliverable: false}, true},
+		{"catch-all is excluded regardless of deliverable", &verifier.Lookup{Deliverable: true, CatchAll: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldCacheLookup(tt.lookup); got != tt.want {
+				t.Errorf("shouldCacheLookup(%+v) = %v, want %v", tt.lookup, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	s := &Service{positiveTTL: 5, negativeTTL: 1}
+
+	if got := cacheTTL(s, &verifier.Lookup{Deliverable: true}); got != 5 {
+		t.Errorf("cacheTTL(deliverable) = %v, want 5", got)
+	}
+	if got := cacheTTL(s, &verifier.Lookup{Deliverable: false}); got != 1 {
+		t.Errorf("cacheTTL(undeliverable) = %v, want 1", got)
+	}
+}
+
+func TestEnvSecondsDuration(t *testing.T) {
+	t.Setenv("TEST_TTL_SECONDS", "30")
+	if got := envSecondsDuration("TEST_TTL_SECONDS"); got.Seconds() != 30 {
+		t.Errorf("envSecondsDuration() = %v, want 30s", got)
+	}
+	if got := envSecondsDuration("TEST_TTL_SECONDS_UNSET"); got != 0 {
+		t.Errorf("envSecondsDuration() for unset var = %v, want 0", got)
+	}
+}
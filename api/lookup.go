@@ -3,6 +3,7 @@ package api
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	raven "github.com/getsentry/raven-go"
 	"github.com/labstack/echo"
@@ -11,9 +12,11 @@ import (
 )
 
 const (
-	FormatJSON  = "JSON"
-	FormatJSONP = "JSONP"
-	FormatXML   = "XML"
+	FormatJSON   = "JSON"
+	FormatJSONP  = "JSONP"
+	FormatXML    = "XML"
+	FormatNDJSON = "NDJSON"
+	FormatCSV    = "CSV"
 )
 
 var (
@@ -41,54 +44,82 @@ func (s *Service) Lookup(c echo.Context) error {
 	address, err := verifier.ParseAddress(email)
 	if err != nil {
 		l.WithError(err).Error("Failed to parse email address")
-		return countAndRespond(c, http.StatusBadRequest, err)
+		return s.countAndRespond(c, http.StatusBadRequest, err)
 	}
 
-	// Check cache for a successful Lookup
-	l.Debug("Checking cache for previous Lookup")
-	if lookup, ok := s.lookupCache.Get(address.MD5Hash); ok {
-		l.WithField("lookup", lookup).Debug("Returning Lookup found in cache")
-		return countAndRespond(c, http.StatusOK, lookup)
+	// Check cache for a successful Lookup, unless the caller opted out
+	// with ?nocache=1 or ?refresh=1 to force a fresh verification
+	nocache := c.QueryParam("nocache") == "1" || c.QueryParam("refresh") == "1"
+	if !nocache {
+		l.Debug("Checking cache for previous Lookup")
+		if lookup, ok := s.lookupCache.Get(address.MD5Hash); ok {
+			s.metrics.cacheLookups.WithLabelValues("hit").Inc()
+			l.WithField("lookup", lookup).Debug("Returning Lookup found in cache")
+			return s.countAndRespond(c, http.StatusOK, lookup)
+		}
+		s.metrics.cacheLookups.WithLabelValues("miss").Inc()
+	}
+
+	// Enforce the per-target-domain SMTP probe quota now, since this is
+	// the point a cache miss is actually about to turn into a real probe
+	if !s.allowDomainProbe(c, address.Domain) {
+		return s.tooManyRequests(c, s.rateLimitConfig.DomainRPS)
 	}
 
 	// Performs the full email verification
 	l.Debug("Performing new email verification")
+	s.metrics.inFlightLookups.Inc()
+	defer s.metrics.inFlightLookups.Dec()
+	start := time.Now()
 	lookup, err := s.verifier.VerifyAddressTimeout(address, s.timeout)
+	s.metrics.lookupDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
 		l.WithError(err).Error("Failed to perform verification")
-		return countAndRespond(c, http.StatusInternalServerError, err)
+		return s.countAndRespond(c, http.StatusInternalServerError, err)
 	}
 	l = l.WithField("lookup", lookup)
 
-	// Store the lookup in cache
-	l.Debug("Caching new Lookup")
-	s.lookupCache.SetDefault(address.MD5Hash, lookup)
+	// Store the lookup in cache, skipping results that came from a
+	// timeout, network error, or catch-all/greylist heuristic so a
+	// merely-unhealthy MX can't pin a bogus verdict for the cache TTL.
+	// ?refresh=1 always overwrites whatever is cached for this address.
+	if refresh := c.QueryParam("refresh") == "1"; refresh || shouldCacheLookup(lookup) {
+		l.Debug("Caching new Lookup")
+		s.lookupCache.Set(address.MD5Hash, lookup, cacheTTL(s, lookup))
+	}
 
 	// Returns the email validation lookup to the requestor
 	l.Debug("Returning Email Lookup")
-	return countAndRespond(c, http.StatusOK, lookup)
+	return s.countAndRespond(c, http.StatusOK, lookup)
 }
 
 // countAndRespond encodes the passed response using the "format" and
 // "callback" parameters on the passed echo.Context
-func countAndRespond(c echo.Context, code int, res interface{}) error {
-	count(res)                   // Submit metrics data
+func (s *Service) countAndRespond(c echo.Context, code int, res interface{}) error {
+	s.count(c, res)              // Submit metrics data
 	return respond(c, code, res) // Encode the response
 }
 
 // count calls out to the various metrics APIs we have set up in order
 // to submit metrics data based on the response
-func count(res interface{}) {
+func (s *Service) count(c echo.Context, res interface{}) {
+	format := strings.ToUpper(c.Param("format"))
 	switch r := res.(type) {
 	case *verifier.Lookup:
 		if r.Deliverable {
 			tinystat.CreateAction("deliverable")
+			s.metrics.lookupsTotal.WithLabelValues("deliverable", format).Inc()
 		} else {
 			tinystat.CreateAction("undeliverable")
+			s.metrics.lookupsTotal.WithLabelValues("undeliverable", format).Inc()
 		}
 	case error:
 		raven.CaptureError(r, nil) // Sentry metrics
 		tinystat.CreateAction("error")
+		s.metrics.lookupsTotal.WithLabelValues("error", format).Inc()
+	case rateLimitedEvent:
+		tinystat.CreateAction("rate_limited")
+		s.metrics.lookupsTotal.WithLabelValues("rate_limited", format).Inc()
 	}
 }
 
@@ -107,6 +138,10 @@ func respond(c echo.Context, code int, res interface{}) error {
 		return c.JSONP(code, callback, res)
 	case FormatXML:
 		return c.XML(code, res)
+	case FormatNDJSON:
+		return respondNDJSON(c, code, res)
+	case FormatCSV:
+		return respondCSV(c, code, res)
 	default:
 		return ErrUnsupportedFormat
 	}
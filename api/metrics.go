@@ -0,0 +1,56 @@
+package api
+
+import (
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors for Lookup traffic. It's owned
+// by Service and registered against Service's own registry rather than
+// the global default, so constructing more than one Service in a process
+// (as tests do) can't panic on a duplicate registration. These collectors
+// live alongside the existing tinystat/Sentry calls in count() rather
+// than replacing them, so current dashboards keep working while operators
+// gain the option to scrape metrics directly with prometheus-client-golang.
+type metrics struct {
+	registry *prometheus.Registry
+
+	lookupsTotal    *prometheus.CounterVec
+	lookupDuration  prometheus.Histogram
+	cacheLookups    *prometheus.CounterVec
+	inFlightLookups prometheus.Gauge
+}
+
+// newMetrics constructs a metrics collector set registered against its
+// own prometheus.Registry
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		lookupsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "trumail_lookups_total",
+			Help: "Total number of email verification lookups, labeled by result and response format",
+		}, []string{"result", "format"}),
+		lookupDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "trumail_lookup_duration_seconds",
+			Help:    "Time spent performing a single email verification",
+			Buckets: prometheus.DefBuckets,
+		}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "trumail_cache_lookups_total",
+			Help: "Total number of lookupCache reads, labeled by hit or miss",
+		}, []string{"result"}),
+		inFlightLookups: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "trumail_inflight_lookups",
+			Help: "Number of email verification lookups currently in flight",
+		}),
+	}
+	m.registry.MustRegister(m.lookupsTotal, m.lookupDuration, m.cacheLookups, m.inFlightLookups)
+	return m
+}
+
+// Metrics exposes this Service's registered Prometheus collectors for scraping
+func (s *Service) Metrics(c echo.Context) error {
+	promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{}).ServeHTTP(c.Response(), c.Request())
+	return nil
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"golang.org/x/time/rate"
+)
+
+// domainLimiterIdleTimeout is how long a per-domain limiter can sit unused
+// before rateLimiterMap's GC reclaims it
+const domainLimiterIdleTimeout = 10 * time.Minute
+
+// ErrRateLimited is returned (as a 429) when a caller exceeds either the
+// per-IP or per-target-domain quota
+var ErrRateLimited = echo.NewHTTPError(http.StatusTooManyRequests, "Rate limit exceeded")
+
+// rateLimitedEvent is the type count() switches on to tally a breach
+// through the existing tinystat/Prometheus path as "rate_limited"
+type rateLimitedEvent struct{}
+
+// RateLimitConfig controls the per-IP and per-domain quotas enforced by
+// Service.RateLimit and Service.allowDomainProbe, populated from
+// RATE_LIMIT_IP_RPS, RATE_LIMIT_DOMAIN_RPS, and RATE_LIMIT_EXEMPT_KEYS
+// at service construction
+type RateLimitConfig struct {
+	IPRPS       float64
+	IPBurst     int
+	DomainRPS   float64
+	DomainBurst int
+	ExemptKeys  map[string]struct{}
+}
+
+// rateLimiterMap is a keyed set of token-bucket limiters shared across
+// concurrent requests (e.g. one per caller IP, or one per target domain),
+// protected by a mutex since multiple goroutines hit it per request
+type rateLimiterMap struct {
+	mu       sync.Mutex
+	rps      rate.Limit
+	burst    int
+	limiters map[string]*rateLimiterEntry
+}
+
+type rateLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiterMap(rps float64, burst int) *rateLimiterMap {
+	m := &rateLimiterMap{
+		rps:      rate.Limit(rps),
+		burst:    burst,
+		limiters: make(map[string]*rateLimiterEntry),
+	}
+	go m.gc()
+	return m
+}
+
+// allow reports whether a request keyed by key is within quota, creating
+// a new limiter for keys seen for the first time
+func (m *rateLimiterMap) allow(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.limiters[key]
+	if !ok {
+		entry = &rateLimiterEntry{limiter: rate.NewLimiter(m.rps, m.burst)}
+		m.limiters[key] = entry
+	}
+	entry.lastSeen = time.Now()
+	return entry.limiter.Allow()
+}
+
+// gc periodically evicts limiters that haven't been used in a while so
+// rateLimiterMap doesn't grow unbounded across distinct IPs/domains
+func (m *rateLimiterMap) gc() {
+	for range time.Tick(domainLimiterIdleTimeout) {
+		m.mu.Lock()
+		for key, entry := range m.limiters {
+			if time.Since(entry.lastSeen) > domainLimiterIdleTimeout {
+				delete(m.limiters, key)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// isRateLimitExempt reports whether the caller's API key is on the
+// configured exempt allowlist, bypassing both the IP and domain quotas
+func (s *Service) isRateLimitExempt(c echo.Context) bool {
+	_, exempt := s.rateLimitConfig.ExemptKeys[c.Request().Header.Get("X-Api-Key")]
+	return exempt
+}
+
+// RateLimit enforces the per-caller-IP quota ahead of Service.Lookup, so
+// a single client can't flood the service with requests regardless of
+// target domain. Callers whose API key is in the exempt allowlist bypass
+// it. The per-target-domain quota is enforced separately by
+// Service.allowDomainProbe, around the actual MX probe rather than here -
+// see its doc comment for why.
+func (s *Service) RateLimit(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.isRateLimitExempt(c) {
+			return next(c)
+		}
+		if !s.ipLimiters.allow(c.RealIP()) {
+			return s.tooManyRequests(c, s.rateLimitConfig.IPRPS)
+		}
+		return next(c)
+	}
+}
+
+// allowDomainProbe enforces the per-target-domain SMTP probe quota. It's
+// meant to be checked around the actual VerifyAddressTimeout call, after
+// a cache lookup has already missed - not in RateLimit middleware ahead
+// of the whole handler, which would charge a popular, mostly-cached
+// domain (gmail.com) for every cache hit and start 429-ing cheap,
+// already-cached lookups instead of protecting the real MX probes the
+// quota exists for.
+func (s *Service) allowDomainProbe(c echo.Context, domain string) bool {
+	return s.isRateLimitExempt(c) || s.domainLimiters.allow(domain)
+}
+
+// tooManyRequests records the breach through the usual count() path and
+// responds 429 with a Retry-After hint derived from the configured rate
+func (s *Service) tooManyRequests(c echo.Context, rps float64) error {
+	retryAfter := 1
+	if rps > 0 {
+		retryAfter = int(1 / rps)
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+	}
+	c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	s.count(c, rateLimitedEvent{})
+	return respond(c, http.StatusTooManyRequests, ErrRateLimited)
+}
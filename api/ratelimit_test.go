@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestRateLimiterMapAllow(t *testing.T) {
+	m := newRateLimiterMap(1, 2)
+
+	if !m.allow("a") {
+		t.Fatal("first request for a new key should be allowed (burst)")
+	}
+	if !m.allow("a") {
+		t.Fatal("second request should still fit in the burst of 2")
+	}
+	if m.allow("a") {
+		t.Fatal("third immediate request should exceed the burst")
+	}
+
+	// a distinct key gets its own bucket
+	if !m.allow("b") {
+		t.Fatal("a different key should have its own independent limiter")
+	}
+}
+
+func TestAllowDomainProbeExemptBypassesLimiter(t *testing.T) {
+	s := &Service{
+		rateLimitConfig: RateLimitConfig{
+			ExemptKeys: map[string]struct{}{"exempt-key": {}},
+		},
+		domainLimiters: newRateLimiterMap(0, 0), // exhausted immediately
+	}
+	req := httptest.NewRequest(http.MethodGet, "/v1/json/user@example.com", nil)
+	req.Header.Set("X-Api-Key", "exempt-key")
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+
+	if !s.allowDomainProbe(c, "example.com") {
+		t.Error("exempt API key should bypass the domain limiter")
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sdwolfe32/trumail/verifier"
+	log "github.com/sirupsen/logrus"
+)
+
+// Service exposes Trumail's HTTP API. It owns the verifier used to
+// perform lookups and the shared state its handlers read from, wired up
+// once at construction from environment configuration.
+type Service struct {
+	log      *log.Entry
+	verifier verifier.Verifier
+	timeout  time.Duration
+
+	lookupCache LookupCache
+	positiveTTL time.Duration
+	negativeTTL time.Duration
+
+	jobStore      JobStore
+	jobQueue      chan string
+	webhookSecret string
+
+	metrics *metrics
+
+	rateLimitConfig RateLimitConfig
+	ipLimiters      *rateLimiterMap
+	domainLimiters  *rateLimiterMap
+}
+
+// defaultRateLimitBurst is the token-bucket burst used for the IP/domain
+// limiters when the caller doesn't configure one explicitly
+const defaultRateLimitBurst = 5
+
+// asyncJobWorkers is how many goroutines drain Service.jobQueue; each
+// worker handles one async verification (and its webhook delivery) at a time
+const asyncJobWorkers = 10
+
+// asyncJobQueueSize bounds how many queued-but-not-yet-running async jobs
+// AsyncLookup will buffer before it starts blocking the caller
+const asyncJobQueueSize = 1000
+
+// NewService constructs a Service ready to be routed to, wiring its
+// response cache backend from CACHE_BACKEND/CACHE_URL (defaulting to the
+// in-memory cache when neither is set), its positive/negative result TTLs
+// from CACHE_POSITIVE_TTL_SECONDS/CACHE_NEGATIVE_TTL_SECONDS (defaulting
+// to the cache's own default expiration when unset), and its async job
+// subsystem (in-memory JobStore, worker pool, and WEBHOOK_SECRET used to
+// sign callback deliveries), its own Prometheus registry for the /metrics
+// endpoint, and its per-IP/per-domain rate limit quotas from
+// RATE_LIMIT_IP_RPS, RATE_LIMIT_DOMAIN_RPS, and the comma-separated
+// RATE_LIMIT_EXEMPT_KEYS allowlist
+func NewService(l *log.Entry, v verifier.Verifier, timeout time.Duration) (*Service, error) {
+	lookupCache, err := NewLookupCache(os.Getenv("CACHE_BACKEND"), os.Getenv("CACHE_URL"))
+	if err != nil {
+		return nil, fmt.Errorf("api: failed to construct lookup cache: %w", err)
+	}
+
+	rateLimitConfig := RateLimitConfig{
+		IPRPS:       envFloat("RATE_LIMIT_IP_RPS", 10),
+		IPBurst:     defaultRateLimitBurst,
+		DomainRPS:   envFloat("RATE_LIMIT_DOMAIN_RPS", 2),
+		DomainBurst: defaultRateLimitBurst,
+		ExemptKeys:  envKeySet("RATE_LIMIT_EXEMPT_KEYS"),
+	}
+
+	s := &Service{
+		log:             l,
+		verifier:        v,
+		timeout:         timeout,
+		lookupCache:     lookupCache,
+		positiveTTL:     envSecondsDuration("CACHE_POSITIVE_TTL_SECONDS"),
+		negativeTTL:     envSecondsDuration("CACHE_NEGATIVE_TTL_SECONDS"),
+		jobStore:        NewMemoryJobStore(),
+		jobQueue:        make(chan string, asyncJobQueueSize),
+		webhookSecret:   os.Getenv("WEBHOOK_SECRET"),
+		metrics:         newMetrics(),
+		rateLimitConfig: rateLimitConfig,
+		ipLimiters:      newRateLimiterMap(rateLimitConfig.IPRPS, rateLimitConfig.IPBurst),
+		domainLimiters:  newRateLimiterMap(rateLimitConfig.DomainRPS, rateLimitConfig.DomainBurst),
+	}
+	s.runAsyncWorkers(asyncJobWorkers)
+	return s, nil
+}
+
+// envSecondsDuration reads an environment variable as a number of seconds,
+// returning 0 (the cache's own default expiration) when unset or invalid
+func envSecondsDuration(key string) time.Duration {
+	secs, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// envFloat reads an environment variable as a float64, falling back to
+// def when unset or invalid
+func envFloat(key string, def float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// envKeySet parses a comma-separated environment variable into a set,
+// used for the rate limit exempt API key allowlist
+func envKeySet(key string) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for _, k := range strings.Split(os.Getenv(key), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys[k] = struct{}{}
+		}
+	}
+	return keys
+}
@@ -0,0 +1,25 @@
+package api
+
+import "testing"
+
+func TestNewLookupCacheSelectsBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend string
+		wantErr bool
+	}{
+		{"defaults to memory", "", false},
+		{"explicit memory", "memory", false},
+		{"redis", "redis", false},
+		{"memcached", "memcached", false},
+		{"unsupported backend errors", "bogus", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLookupCache(tt.backend, "localhost:6379")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewLookupCache(%q) error = %v, wantErr %v", tt.backend, err, tt.wantErr)
+			}
+		})
+	}
+}